@@ -29,10 +29,25 @@ type StorageInfo struct {
 	CanSeal  bool
 	CanStore bool
 
+	// AllowedSizes is the set of sector sizes this path accepts, set at
+	// StorageAttach time. StorageBestAlloc and StorageFindSector only
+	// consider a path for a sector whose size is in this list; an empty
+	// list accepts every size.
+	AllowedSizes []abi.SectorSize
+
 	LastHeartbeat time.Time
 	HeartbeatErr  error
 }
 
+// PathType distinguishes a path used as scratch space while a sector is
+// being sealed from one used to store already-sealed sectors long-term.
+type PathType bool
+
+const (
+	PathStorage PathType = false
+	PathSealing PathType = true
+)
+
 type HealthReport struct {
 	Stat FsStat
 	Err  error
@@ -43,11 +58,19 @@ type SectorIndex interface { // part of storage-miner api
 	StorageInfo(context.Context, ID) (StorageInfo, error)
 	StorageReportHealth(context.Context, ID, HealthReport) error
 
-	StorageDeclareSector(ctx context.Context, storageId ID, s abi.SectorID, ft SectorFileType) error
+	StorageDeclareSector(ctx context.Context, storageId ID, s abi.SectorID, ft SectorFileType, primary bool) error
 	StorageDropSector(ctx context.Context, storageId ID, s abi.SectorID, ft SectorFileType) error
-	StorageFindSector(ctx context.Context, sector abi.SectorID, ft SectorFileType, allowFetch bool) ([]StorageInfo, error)
+	StorageFindSector(ctx context.Context, sector abi.SectorID, ft SectorFileType, ssize abi.SectorSize, allowFetch bool) ([]SectorStorageInfo, error)
+	StorageRedeclare(ctx context.Context, id ID, dropMissing bool) error
+
+	StorageBestAlloc(ctx context.Context, allocate SectorFileType, ssize abi.SectorSize, pathType PathType) ([]StorageInfo, error)
+	StorageReserve(ctx context.Context, id ID, s abi.SectorID, ft SectorFileType, bytes int64) (func(), error)
 
-	StorageBestAlloc(ctx context.Context, allocate SectorFileType, spt abi.RegisteredProof, sealing bool) ([]StorageInfo, error)
+	StorageLock(ctx context.Context, s abi.SectorID, read SectorFileType, write SectorFileType) (func(), error)
+	StorageTryLock(ctx context.Context, s abi.SectorID, read SectorFileType, write SectorFileType) (func(), error)
+	StorageGetLocks(ctx context.Context) ([]SectorLock, error)
+
+	StorageDetach(ctx context.Context, id ID, ssize abi.SectorSize, opts DetachOpts) ([]SectorMigration, error)
 }
 
 type Decl struct {
@@ -55,28 +78,340 @@ type Decl struct {
 	SectorFileType
 }
 
+// declMeta records, for a single (sector, file type) declaration, which
+// storage the copy lives on, whether it is the primary (origin) copy, and
+// when it was last (re)declared (used by StorageRedeclare to find stale
+// declarations).
+type declMeta struct {
+	ID       ID
+	Primary  bool
+	Declared time.Time
+}
+
+// SectorStorageInfo is StorageInfo for a copy of a sector, annotated with
+// whether that copy is the primary (origin) copy of the sector.
+type SectorStorageInfo struct {
+	StorageInfo
+
+	Primary bool
+}
+
 type storageEntry struct {
 	info *StorageInfo
 	fsi  FsStat
 
 	lastHeartbeat time.Time
 	heartbeatErr  error
+
+	// reserved is the sum of bytes set aside by in-flight StorageReserve
+	// calls that haven't been released yet, keyed by the (sector, file
+	// type) that requested them so a caller can't double-reserve the same
+	// allocation.
+	reserved     int64
+	reservations map[Decl]int64
+}
+
+// snapshotInterval is how many journaled operations an Index lets accumulate
+// before persist compacts them into a fresh snapshot.
+const snapshotInterval = 256
+
+// sectorLock tracks, per SectorFileType bit, whether a sector's file is
+// being read (possibly by multiple callers) or written (exclusively). A
+// write lock on one bit (e.g. FTUnsealed) has no effect on any other bit
+// (e.g. FTSealed|FTCache).
+type sectorLock struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	readers map[SectorFileType]int
+	writer  map[SectorFileType]bool
+
+	// refs counts callers currently waiting on or holding this lock, so
+	// putSectorLock knows when it's safe to drop the entry from
+	// Index.sectorLocks. Guarded by mu.
+	refs int
+}
+
+func newSectorLock() *sectorLock {
+	sl := &sectorLock{
+		readers: map[SectorFileType]int{},
+		writer:  map[SectorFileType]bool{},
+	}
+	sl.cond = sync.NewCond(&sl.mu)
+	return sl
+}
+
+// canLock reports whether read/write can be acquired right now. Must be
+// called with sl.mu held.
+func (sl *sectorLock) canLock(read, write SectorFileType) bool {
+	for _, ft := range PathTypes {
+		if read&ft == 0 && write&ft == 0 {
+			continue
+		}
+		if sl.writer[ft] {
+			return false
+		}
+		if write&ft != 0 && sl.readers[ft] > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// acquire records read/write as held. Must be called with sl.mu held, and
+// only once canLock has returned true.
+func (sl *sectorLock) acquire(read, write SectorFileType) {
+	for _, ft := range PathTypes {
+		switch {
+		case write&ft != 0:
+			sl.writer[ft] = true
+		case read&ft != 0:
+			sl.readers[ft]++
+		}
+	}
+}
+
+func (sl *sectorLock) releaseFunc(read, write SectorFileType) func() {
+	return func() {
+		sl.mu.Lock()
+		for _, ft := range PathTypes {
+			switch {
+			case write&ft != 0:
+				sl.writer[ft] = false
+			case read&ft != 0:
+				sl.readers[ft]--
+			}
+		}
+		sl.mu.Unlock()
+
+		sl.cond.Broadcast()
+	}
+}
+
+// SectorFileLock describes the read/write state of a single SectorFileType
+// bit held against a sector, for StorageGetLocks diagnostics.
+type SectorFileLock struct {
+	Type  SectorFileType
+	Read  int
+	Write bool
+}
+
+// SectorLock is the set of active locks against a single sector.
+type SectorLock struct {
+	Sector abi.SectorID
+	Locks  []SectorFileLock
+}
+
+// DetachMode selects how StorageDetach behaves when a storage path still
+// has sectors declared on it.
+type DetachMode int
+
+const (
+	// DetachForce drops the path immediately, removing every sector
+	// declaration that referenced it.
+	DetachForce DetachMode = iota
+	// DetachIfReplicated fails unless every sector declared on this path
+	// has another declaration of the same SectorFileType elsewhere.
+	DetachIfReplicated
+	// DetachMigrate doesn't remove anything itself; it returns the moves
+	// the caller must perform (StorageDeclareSector on the target,
+	// StorageDropSector on the source) before the path can be dropped.
+	// Once no sectors remain declared on the path, it drops the path like
+	// DetachForce.
+	DetachMigrate
+)
+
+type DetachOpts struct {
+	Mode DetachMode
+}
+
+// SectorMigration is one (sector, file type) move StorageDetach's
+// DetachMigrate plan asks the caller to perform.
+type SectorMigration struct {
+	Sector   abi.SectorID
+	FileType SectorFileType
+	Target   ID
 }
 
 type Index struct {
 	lk sync.RWMutex
 
-	sectors map[Decl][]ID
+	sectors map[Decl][]declMeta
 	stores  map[ID]*storageEntry
+
+	store  IndexStore
+	opsLog int
+
+	sectorLk    sync.Mutex
+	sectorLocks map[abi.SectorID]*sectorLock
 }
 
-func NewIndex() *Index {
-	return &Index{
-		sectors: map[Decl][]ID{},
+// NewIndex creates a new Index. If store is non-nil, the returned Index
+// replays its journal before returning and write-throughs every mutating
+// call to it afterwards.
+func NewIndex(ctx context.Context, store IndexStore) (*Index, error) {
+	i := &Index{
+		sectors: map[Decl][]declMeta{},
 		stores:  map[ID]*storageEntry{},
+
+		store: store,
+
+		sectorLocks: map[abi.SectorID]*sectorLock{},
+	}
+
+	if store == nil {
+		return i, nil
+	}
+
+	snap, entries, err := store.Load(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("loading persisted sector index: %w", err)
+	}
+
+	if snap != nil {
+		for _, se := range snap.Stores {
+			si := se.Info
+			i.stores[si.ID] = &storageEntry{
+				info: &si,
+				fsi:  se.Fsi,
+
+				lastHeartbeat: time.Now(),
+			}
+		}
+		for _, sd := range snap.Sectors {
+			i.sectors[Decl{sd.Sector, sd.FileType}] = sd.Entries
+		}
+	}
+
+	for _, e := range entries {
+		i.applyLogEntry(e)
+	}
+
+	return i, nil
+}
+
+// applyLogEntry replays a single journal entry into the in-memory maps. It
+// must not itself journal the change, since the entry came from the journal.
+func (i *Index) applyLogEntry(e indexLogEntry) {
+	switch e.Kind {
+	case indexOpAttach:
+		if e.Storage == nil || e.Fsi == nil {
+			log.Warnf("skipping malformed attach log entry")
+			return
+		}
+		si := *e.Storage
+		i.stores[si.ID] = &storageEntry{
+			info: &si,
+			fsi:  *e.Fsi,
+
+			lastHeartbeat: time.Now(),
+		}
+	case indexOpDeclare:
+		for _, fileType := range PathTypes {
+			if fileType&e.FileType == 0 {
+				continue
+			}
+
+			d := Decl{e.Sector, fileType}
+
+			did := -1
+			for j, sid := range i.sectors[d] {
+				if sid.ID == e.StorageID {
+					did = j
+					break
+				}
+			}
+			if did == -1 {
+				i.sectors[d] = append(i.sectors[d], declMeta{ID: e.StorageID, Primary: e.Primary})
+				did = len(i.sectors[d]) - 1
+			}
+
+			if e.Primary {
+				for j := range i.sectors[d] {
+					if j != did {
+						i.sectors[d][j].Primary = false
+					}
+				}
+			}
+			i.sectors[d][did].Primary = e.Primary
+			i.sectors[d][did].Declared = time.Now()
+		}
+	case indexOpDrop:
+		for _, fileType := range PathTypes {
+			if fileType&e.FileType == 0 {
+				continue
+			}
+
+			d := Decl{e.Sector, fileType}
+
+			rewritten := make([]declMeta, 0, len(i.sectors[d]))
+			for _, sid := range i.sectors[d] {
+				if sid.ID == e.StorageID {
+					continue
+				}
+				rewritten = append(rewritten, sid)
+			}
+			if len(rewritten) == 0 {
+				delete(i.sectors, d)
+				continue
+			}
+			i.sectors[d] = rewritten
+		}
+	case indexOpDetachStore:
+		delete(i.stores, e.StorageID)
+	default:
+		log.Warnf("unknown index log entry kind: %s", e.Kind)
+	}
+}
+
+// persist journals entry, and periodically compacts the journal into a fresh
+// snapshot, if this Index has an IndexStore attached. Must be called with
+// i.lk held.
+func (i *Index) persist(ctx context.Context, entry indexLogEntry) {
+	if i.store == nil {
+		return
+	}
+
+	if err := i.store.Append(ctx, entry); err != nil {
+		log.Errorf("persisting sector index operation: %+v", err)
+		return
+	}
+
+	i.opsLog++
+	if i.opsLog < snapshotInterval {
+		return
+	}
+	i.opsLog = 0
+
+	if err := i.store.Snapshot(ctx, i.snapshotLocked()); err != nil {
+		log.Errorf("snapshotting sector index: %+v", err)
 	}
 }
 
+// snapshotLocked builds a JSON-friendly copy of the current state. Must be
+// called with i.lk held.
+func (i *Index) snapshotLocked() *indexSnapshot {
+	snap := &indexSnapshot{}
+
+	for _, se := range i.stores {
+		snap.Stores = append(snap.Stores, storeSnapshot{
+			Info: *se.info,
+			Fsi:  se.fsi,
+		})
+	}
+
+	for d, entries := range i.sectors {
+		snap.Sectors = append(snap.Sectors, sectorDeclSnapshot{
+			Sector:   d.SectorID,
+			FileType: d.SectorFileType,
+			Entries:  entries,
+		})
+	}
+
+	return snap
+}
+
 func (i *Index) StorageList(ctx context.Context) (map[ID][]Decl, error) {
 	i.lk.RLock()
 	defer i.lk.RUnlock()
@@ -88,7 +423,7 @@ func (i *Index) StorageList(ctx context.Context) (map[ID][]Decl, error) {
 	}
 	for decl, ids := range i.sectors {
 		for _, id := range ids {
-			byID[id][decl.SectorID] |= decl.SectorFileType
+			byID[id.ID][decl.SectorID] |= decl.SectorFileType
 		}
 	}
 
@@ -130,6 +465,14 @@ func (i *Index) StorageAttach(ctx context.Context, si StorageInfo, st FsStat) er
 			i.stores[si.ID].info.URLs = append(i.stores[si.ID].info.URLs, u)
 		}
 
+		i.stores[si.ID].lastHeartbeat = time.Now()
+
+		i.persist(ctx, indexLogEntry{
+			Kind:    indexOpAttach,
+			Storage: i.stores[si.ID].info,
+			Fsi:     &i.stores[si.ID].fsi,
+		})
+
 		return nil
 	}
 	i.stores[si.ID] = &storageEntry{
@@ -138,6 +481,13 @@ func (i *Index) StorageAttach(ctx context.Context, si StorageInfo, st FsStat) er
 
 		lastHeartbeat: time.Now(),
 	}
+
+	i.persist(ctx, indexLogEntry{
+		Kind:    indexOpAttach,
+		Storage: i.stores[si.ID].info,
+		Fsi:     &i.stores[si.ID].fsi,
+	})
+
 	return nil
 }
 
@@ -151,13 +501,206 @@ func (i *Index) StorageReportHealth(ctx context.Context, id ID, report HealthRep
 	}
 
 	ent.fsi = report.Stat
+	ent.fsi.Reserved = ent.reserved // worker health reports don't know about our pending reservations
 	ent.heartbeatErr = report.Err
 	ent.lastHeartbeat = time.Now()
 
 	return nil
 }
 
-func (i *Index) StorageDeclareSector(ctx context.Context, storageId ID, s abi.SectorID, ft SectorFileType) error {
+// StorageReserve sets aside bytes on storage id for an in-flight allocation
+// of (s, ft), so that a concurrent StorageBestAlloc call doesn't pick the
+// same path for another allocation before the first one has actually
+// written anything (and so updated FsStat.Available). Call the returned
+// release func once the allocation has landed, or been abandoned.
+func (i *Index) StorageReserve(ctx context.Context, id ID, s abi.SectorID, ft SectorFileType, bytes int64) (func(), error) {
+	i.lk.Lock()
+	defer i.lk.Unlock()
+
+	ent, ok := i.stores[id]
+	if !ok {
+		return nil, xerrors.Errorf("storage reserve: storage %s is not attached", id)
+	}
+
+	d := Decl{s, ft}
+	if _, ok := ent.reservations[d]; ok {
+		return nil, xerrors.Errorf("reservation already exists for sector %v %s on %s", s, ft, id)
+	}
+
+	if ent.reservations == nil {
+		ent.reservations = map[Decl]int64{}
+	}
+	ent.reservations[d] = bytes
+	ent.reserved += bytes
+	ent.fsi.Reserved = ent.reserved
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			i.lk.Lock()
+			defer i.lk.Unlock()
+
+			ent, ok := i.stores[id]
+			if !ok {
+				return
+			}
+
+			delete(ent.reservations, d)
+			ent.reserved -= bytes
+			ent.fsi.Reserved = ent.reserved
+		})
+	}
+
+	return release, nil
+}
+
+// getSectorLock returns the sectorLock for s, creating it if necessary, and
+// marks it as referenced so putSectorLock won't drop it out from under the
+// caller. Every getSectorLock must be matched by exactly one putSectorLock.
+func (i *Index) getSectorLock(s abi.SectorID) *sectorLock {
+	i.sectorLk.Lock()
+	defer i.sectorLk.Unlock()
+
+	sl, ok := i.sectorLocks[s]
+	if !ok {
+		sl = newSectorLock()
+		i.sectorLocks[s] = sl
+	}
+
+	sl.mu.Lock()
+	sl.refs++
+	sl.mu.Unlock()
+
+	return sl
+}
+
+// putSectorLock releases the reference taken by getSectorLock and, if sl is
+// now unused (no one waiting on or holding it), removes it from
+// i.sectorLocks so a long-running miner doesn't accumulate one sectorLock
+// per sector it has ever touched.
+func (i *Index) putSectorLock(s abi.SectorID, sl *sectorLock) {
+	i.sectorLk.Lock()
+	defer i.sectorLk.Unlock()
+
+	sl.mu.Lock()
+	sl.refs--
+	idle := sl.refs == 0
+	sl.mu.Unlock()
+
+	if idle && i.sectorLocks[s] == sl {
+		delete(i.sectorLocks, s)
+	}
+}
+
+// StorageLock blocks until it can acquire read on every file type bit set
+// in read and write (exclusive) on every bit set in write, for sector s. It
+// gives up early if ctx is done. Call the returned func to release.
+func (i *Index) StorageLock(ctx context.Context, s abi.SectorID, read SectorFileType, write SectorFileType) (func(), error) {
+	sl := i.getSectorLock(s)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Hold sl.mu across the broadcast so it can't land between the
+			// loop below's ctx.Err() check and its cond.Wait() call, which
+			// would otherwise be lost and leave the waiter parked forever.
+			sl.mu.Lock()
+			sl.cond.Broadcast()
+			sl.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	sl.mu.Lock()
+
+	for !sl.canLock(read, write) {
+		if err := ctx.Err(); err != nil {
+			sl.mu.Unlock()
+			i.putSectorLock(s, sl)
+			return nil, err
+		}
+		sl.cond.Wait()
+	}
+
+	sl.acquire(read, write)
+	sl.mu.Unlock()
+
+	rel := sl.releaseFunc(read, write)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			rel()
+			i.putSectorLock(s, sl)
+		})
+	}, nil
+}
+
+// StorageTryLock is the non-blocking form of StorageLock: it returns a nil
+// unlock func (and no error) if the lock isn't immediately available.
+func (i *Index) StorageTryLock(ctx context.Context, s abi.SectorID, read SectorFileType, write SectorFileType) (func(), error) {
+	sl := i.getSectorLock(s)
+
+	sl.mu.Lock()
+
+	if !sl.canLock(read, write) {
+		sl.mu.Unlock()
+		i.putSectorLock(s, sl)
+		return nil, nil
+	}
+
+	sl.acquire(read, write)
+	sl.mu.Unlock()
+
+	rel := sl.releaseFunc(read, write)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			rel()
+			i.putSectorLock(s, sl)
+		})
+	}, nil
+}
+
+// StorageGetLocks reports every sector with an outstanding read or write
+// lock, for diagnosing what's blocking a stuck job.
+func (i *Index) StorageGetLocks(ctx context.Context) ([]SectorLock, error) {
+	i.sectorLk.Lock()
+	defer i.sectorLk.Unlock()
+
+	out := make([]SectorLock, 0, len(i.sectorLocks))
+
+	for s, sl := range i.sectorLocks {
+		sl.mu.Lock()
+
+		var locks []SectorFileLock
+		for _, ft := range PathTypes {
+			if sl.readers[ft] > 0 || sl.writer[ft] {
+				locks = append(locks, SectorFileLock{
+					Type:  ft,
+					Read:  sl.readers[ft],
+					Write: sl.writer[ft],
+				})
+			}
+		}
+
+		sl.mu.Unlock()
+
+		if len(locks) == 0 {
+			continue
+		}
+
+		out = append(out, SectorLock{
+			Sector: s,
+			Locks:  locks,
+		})
+	}
+
+	return out, nil
+}
+
+func (i *Index) StorageDeclareSector(ctx context.Context, storageId ID, s abi.SectorID, ft SectorFileType, primary bool) error {
 	i.lk.Lock()
 	defer i.lk.Unlock()
 
@@ -168,14 +711,104 @@ func (i *Index) StorageDeclareSector(ctx context.Context, storageId ID, s abi.Se
 
 		d := Decl{s, fileType}
 
-		for _, sid := range i.sectors[d] {
-			if sid == storageId {
-				log.Warnf("sector %v redeclared in %s", s, storageId)
-				return nil
+		did := -1
+		for j, sid := range i.sectors[d] {
+			if sid.ID == storageId {
+				did = j
+				break
+			}
+		}
+
+		if did == -1 {
+			i.sectors[d] = append(i.sectors[d], declMeta{
+				ID:      storageId,
+				Primary: primary,
+			})
+			did = len(i.sectors[d]) - 1
+		} else if i.sectors[d][did].Primary == primary {
+			log.Warnf("sector %v redeclared in %s", s, storageId)
+			i.sectors[d][did].Declared = time.Now()
+			continue
+		}
+
+		if primary {
+			for j := range i.sectors[d] {
+				if j == did {
+					continue
+				}
+
+				if i.sectors[d][j].Primary {
+					log.Warnf("sector %v: multiple primary copies, dropping primary from %s", s, i.sectors[d][j].ID)
+					i.sectors[d][j].Primary = false
+				}
+			}
+		}
+
+		i.sectors[d][did].Primary = primary
+		i.sectors[d][did].Declared = time.Now()
+	}
+
+	i.persist(ctx, indexLogEntry{
+		Kind:      indexOpDeclare,
+		StorageID: storageId,
+		Sector:    s,
+		FileType:  ft,
+		Primary:   primary,
+	})
+
+	return nil
+}
+
+// StorageRedeclare reconciles this index's declarations for storage id
+// against what a worker has just (re-)declared after reconnecting. Call
+// StorageAttach first (which marks the path's reconciliation start), then
+// StorageDeclareSector for every sector the worker actually finds, then
+// StorageRedeclare. If dropMissing is set, any declaration for id that
+// predates the reconciliation start - i.e. wasn't refreshed - is dropped.
+func (i *Index) StorageRedeclare(ctx context.Context, id ID, dropMissing bool) error {
+	i.lk.Lock()
+	defer i.lk.Unlock()
+
+	st, ok := i.stores[id]
+	if !ok {
+		return xerrors.Errorf("redeclare: storage %s is not attached", id)
+	}
+
+	if !dropMissing {
+		return nil
+	}
+
+	since := st.lastHeartbeat
+
+	for d, entries := range i.sectors {
+		var rewritten []declMeta
+		changed := false
+
+		for _, e := range entries {
+			if e.ID == id && e.Declared.Before(since) {
+				log.Warnf("dropping stale declaration of %v in %s (not redeclared)", d.SectorID, id)
+				changed = true
+				continue
 			}
+			rewritten = append(rewritten, e)
+		}
+
+		if !changed {
+			continue
+		}
+
+		if len(rewritten) == 0 {
+			delete(i.sectors, d)
+		} else {
+			i.sectors[d] = rewritten
 		}
 
-		i.sectors[d] = append(i.sectors[d], storageId)
+		i.persist(ctx, indexLogEntry{
+			Kind:      indexOpDrop,
+			StorageID: id,
+			Sector:    d.SectorID,
+			FileType:  d.SectorFileType,
+		})
 	}
 
 	return nil
@@ -193,12 +826,12 @@ func (i *Index) StorageDropSector(ctx context.Context, storageId ID, s abi.Secto
 		d := Decl{s, fileType}
 
 		if len(i.sectors[d]) == 0 {
-			return nil
+			continue
 		}
 
-		rewritten := make([]ID, 0, len(i.sectors[d])-1)
+		rewritten := make([]declMeta, 0, len(i.sectors[d])-1)
 		for _, sid := range i.sectors[d] {
-			if sid == storageId {
+			if sid.ID == storageId {
 				continue
 			}
 
@@ -206,32 +839,230 @@ func (i *Index) StorageDropSector(ctx context.Context, storageId ID, s abi.Secto
 		}
 		if len(rewritten) == 0 {
 			delete(i.sectors, d)
-			return nil
+			continue
 		}
 
 		i.sectors[d] = rewritten
 	}
 
+	i.persist(ctx, indexLogEntry{
+		Kind:      indexOpDrop,
+		StorageID: storageId,
+		Sector:    s,
+		FileType:  ft,
+	})
+
 	return nil
 }
 
-func (i *Index) StorageFindSector(ctx context.Context, s abi.SectorID, ft SectorFileType, allowFetch bool) ([]StorageInfo, error) {
+// StorageDetach removes storage id from the index. opts.Mode controls what
+// happens to sectors still declared on it: see DetachForce,
+// DetachIfReplicated and DetachMigrate. ssize is the sector size of every
+// sector declared on id, used by DetachMigrate to plan migrations onto
+// targets that can actually hold them.
+func (i *Index) StorageDetach(ctx context.Context, id ID, ssize abi.SectorSize, opts DetachOpts) ([]SectorMigration, error) {
+	i.lk.Lock()
+	defer i.lk.Unlock()
+
+	if _, ok := i.stores[id]; !ok {
+		return nil, xerrors.Errorf("storage detach: storage %s is not attached", id)
+	}
+
+	type declRef struct {
+		d       Decl
+		primary bool
+	}
+
+	var refs []declRef
+	for d, entries := range i.sectors {
+		for _, e := range entries {
+			if e.ID == id {
+				refs = append(refs, declRef{d: d, primary: e.Primary})
+				break
+			}
+		}
+	}
+
+	switch opts.Mode {
+	case DetachForce:
+		// fallthrough to the drop loop below
+
+	case DetachIfReplicated:
+		for _, r := range refs {
+			if len(i.sectors[r.d]) <= 1 {
+				return nil, xerrors.Errorf("refusing to detach %s: sector %v (%s) has no other copy", id, r.d.SectorID, r.d.SectorFileType)
+			}
+		}
+
+	case DetachMigrate:
+		if len(refs) == 0 {
+			// nothing left to move: drop the path like DetachForce
+			break
+		}
+
+		plan := make([]SectorMigration, 0, len(refs))
+
+		// committed tracks bytes already planned onto each target by earlier
+		// entries in this same plan, so bestMigrationTargetLocked doesn't
+		// keep picking the single best-weighted target for every sector
+		// without accounting for space it has already been assigned (the
+		// same race StorageReserve guards against for live allocations).
+		committed := map[ID]int64{}
+
+		for _, r := range refs {
+			if r.primary {
+				otherPrimary := false
+				for _, e := range i.sectors[r.d] {
+					if e.ID != id && e.Primary {
+						otherPrimary = true
+						break
+					}
+				}
+				if !otherPrimary {
+					return nil, xerrors.Errorf("refusing to detach %s: sector %v (%s) is the last primary copy", id, r.d.SectorID, r.d.SectorFileType)
+				}
+			}
+
+			spaceReq, err := r.d.SectorFileType.SealSpaceUse(ssize)
+			if err != nil {
+				return nil, xerrors.Errorf("estimating required space for sector %v (%s): %w", r.d.SectorID, r.d.SectorFileType, err)
+			}
+
+			target, err := i.bestMigrationTargetLocked(id, ssize, spaceReq, committed)
+			if err != nil {
+				return nil, xerrors.Errorf("planning migration of sector %v (%s): %w", r.d.SectorID, r.d.SectorFileType, err)
+			}
+
+			committed[target] += spaceReq
+
+			plan = append(plan, SectorMigration{
+				Sector:   r.d.SectorID,
+				FileType: r.d.SectorFileType,
+				Target:   target,
+			})
+		}
+
+		// DetachMigrate never mutates the index itself: id stays attached
+		// until the caller has confirmed every move (StorageDeclareSector
+		// on the target, StorageDropSector on id) and calls StorageDetach
+		// again, by which point refs will be empty and DetachForce applies.
+		return plan, nil
+
+	default:
+		return nil, xerrors.Errorf("unknown detach mode %d", opts.Mode)
+	}
+
+	for _, r := range refs {
+		rewritten := make([]declMeta, 0, len(i.sectors[r.d]))
+		for _, e := range i.sectors[r.d] {
+			if e.ID != id {
+				rewritten = append(rewritten, e)
+			}
+		}
+
+		if len(rewritten) == 0 {
+			delete(i.sectors, r.d)
+		} else {
+			i.sectors[r.d] = rewritten
+		}
+
+		i.persist(ctx, indexLogEntry{
+			Kind:      indexOpDrop,
+			StorageID: id,
+			Sector:    r.d.SectorID,
+			FileType:  r.d.SectorFileType,
+		})
+	}
+
+	i.persist(ctx, indexLogEntry{
+		Kind:      indexOpDetachStore,
+		StorageID: id,
+	})
+
+	delete(i.stores, id)
+
+	return nil, nil
+}
+
+// bestMigrationTargetLocked picks the detach migration target with the
+// largest free space times Weight, like StorageBestAlloc's ordering except
+// that free space here is Available minus reserved and committed bytes,
+// excluding exclude and any
+// target whose AllowedSizes doesn't include ssize. committed holds bytes
+// already planned onto each target by earlier sectors in the same
+// StorageDetach call, and is checked against spaceReq so a single target
+// isn't planned past its actual free space. Must be called with i.lk held.
+func (i *Index) bestMigrationTargetLocked(exclude ID, ssize abi.SectorSize, spaceReq int64, committed map[ID]int64) (ID, error) {
+	var best ID
+	var bestWeight big.Int
+	found := false
+
+	for sid, p := range i.stores {
+		if sid == exclude || !p.info.CanStore {
+			continue
+		}
+		if !allowedSize(p.info.AllowedSizes, ssize) {
+			continue
+		}
+		if time.Since(p.lastHeartbeat) > SkippedHeartbeatThresh || p.heartbeatErr != nil {
+			continue
+		}
+
+		available := p.fsi.Available - p.reserved - committed[sid]
+		if spaceReq > available {
+			continue
+		}
+
+		weight := big.Mul(big.NewInt(int64(available)), big.NewInt(int64(p.info.Weight)))
+		if !found || weight.GreaterThan(bestWeight) {
+			best = sid
+			bestWeight = weight
+			found = true
+		}
+	}
+
+	if !found {
+		return "", xerrors.New("no migration target available")
+	}
+
+	return best, nil
+}
+
+// allowedSize reports whether ssize is in allowed, or allowed is unset
+// (paths declared before AllowedSizes existed accept anything).
+func allowedSize(allowed []abi.SectorSize, ssize abi.SectorSize) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, as := range allowed {
+		if as == ssize {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (i *Index) StorageFindSector(ctx context.Context, s abi.SectorID, ft SectorFileType, ssize abi.SectorSize, allowFetch bool) ([]SectorStorageInfo, error) {
 	i.lk.RLock()
 	defer i.lk.RUnlock()
 
 	storageIDs := map[ID]uint64{}
+	isPrimary := map[ID]bool{}
 
-	for _, pathType := range PathTypes {
-		if ft&pathType == 0 {
+	for _, fileType := range PathTypes {
+		if ft&fileType == 0 {
 			continue
 		}
 
-		for _, id := range i.sectors[Decl{s, pathType}] {
-			storageIDs[id]++
+		for _, id := range i.sectors[Decl{s, fileType}] {
+			storageIDs[id.ID]++
+			isPrimary[id.ID] = isPrimary[id.ID] || id.Primary
 		}
 	}
 
-	out := make([]StorageInfo, 0, len(storageIDs))
+	out := make([]SectorStorageInfo, 0, len(storageIDs))
 
 	for id, n := range storageIDs {
 		st, ok := i.stores[id]
@@ -240,6 +1071,10 @@ func (i *Index) StorageFindSector(ctx context.Context, s abi.SectorID, ft Sector
 			continue
 		}
 
+		if !allowedSize(st.info.AllowedSizes, ssize) {
+			continue
+		}
+
 		urls := make([]string, len(st.info.URLs))
 		for k, u := range st.info.URLs {
 			rl, err := url.Parse(u)
@@ -251,12 +1086,15 @@ func (i *Index) StorageFindSector(ctx context.Context, s abi.SectorID, ft Sector
 			urls[k] = rl.String()
 		}
 
-		out = append(out, StorageInfo{
-			ID:       id,
-			URLs:     urls,
-			Weight:   st.info.Weight * n, // storage with more sector types is better
-			CanSeal:  st.info.CanSeal,
-			CanStore: st.info.CanStore,
+		out = append(out, SectorStorageInfo{
+			StorageInfo: StorageInfo{
+				ID:       id,
+				URLs:     urls,
+				Weight:   st.info.Weight * n, // storage with more sector types is better
+				CanSeal:  st.info.CanSeal,
+				CanStore: st.info.CanStore,
+			},
+			Primary: isPrimary[id],
 		})
 	}
 
@@ -266,6 +1104,10 @@ func (i *Index) StorageFindSector(ctx context.Context, s abi.SectorID, ft Sector
 				continue
 			}
 
+			if !allowedSize(st.info.AllowedSizes, ssize) {
+				continue
+			}
+
 			urls := make([]string, len(st.info.URLs))
 			for k, u := range st.info.URLs {
 				rl, err := url.Parse(u)
@@ -277,12 +1119,15 @@ func (i *Index) StorageFindSector(ctx context.Context, s abi.SectorID, ft Sector
 				urls[k] = rl.String()
 			}
 
-			out = append(out, StorageInfo{
-				ID:       id,
-				URLs:     urls,
-				Weight:   st.info.Weight * 0, // TODO: something better than just '0'
-				CanSeal:  st.info.CanSeal,
-				CanStore: st.info.CanStore,
+			out = append(out, SectorStorageInfo{
+				StorageInfo: StorageInfo{
+					ID:       id,
+					URLs:     urls,
+					Weight:   st.info.Weight * 0, // TODO: something better than just '0'
+					CanSeal:  st.info.CanSeal,
+					CanStore: st.info.CanStore,
+				},
+				Primary: false,
 			})
 		}
 	}
@@ -302,27 +1147,32 @@ func (i *Index) StorageInfo(ctx context.Context, id ID) (StorageInfo, error) {
 	return *si.info, nil
 }
 
-func (i *Index) StorageBestAlloc(ctx context.Context, allocate SectorFileType, spt abi.RegisteredProof, sealing bool) ([]StorageInfo, error) {
+func (i *Index) StorageBestAlloc(ctx context.Context, allocate SectorFileType, ssize abi.SectorSize, pathType PathType) ([]StorageInfo, error) {
 	i.lk.RLock()
 	defer i.lk.RUnlock()
 
 	var candidates []storageEntry
 
-	spaceReq, err := allocate.SealSpaceUse(spt)
+	spaceReq, err := allocate.SealSpaceUse(ssize)
 	if err != nil {
 		return nil, xerrors.Errorf("estimating required space: %w", err)
 	}
 
 	for _, p := range i.stores {
-		if sealing && !p.info.CanSeal {
+		if pathType == PathSealing && !p.info.CanSeal {
 			continue
 		}
-		if !sealing && !p.info.CanStore {
+		if pathType == PathStorage && !p.info.CanStore {
+			continue
+		}
+
+		if !allowedSize(p.info.AllowedSizes, ssize) {
+			log.Debugf("not allocating on %s, sector size %d not in allowed sizes", p.info.ID, ssize)
 			continue
 		}
 
-		if spaceReq > p.fsi.Available {
-			log.Debugf("not allocating on %s, out of space (available: %d, need: %d)", p.info.ID, p.fsi.Available, spaceReq)
+		if spaceReq > p.fsi.Available-p.reserved {
+			log.Debugf("not allocating on %s, out of space (available: %d, reserved: %d, need: %d)", p.info.ID, p.fsi.Available, p.reserved, spaceReq)
 			continue
 		}
 
@@ -362,10 +1212,17 @@ func (i *Index) FindSector(id abi.SectorID, typ SectorFileType) ([]ID, error) {
 	i.lk.RLock()
 	defer i.lk.RUnlock()
 
-	return i.sectors[Decl{
+	spt := i.sectors[Decl{
 		SectorID:       id,
 		SectorFileType: typ,
-	}], nil
+	}]
+
+	out := make([]ID, len(spt))
+	for k, sp := range spt {
+		out[k] = sp.ID
+	}
+
+	return out, nil
 }
 
 var _ SectorIndex = &Index{}
@@ -0,0 +1,77 @@
+package stores
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// TestAllowedSizesFiltering checks that a path whose AllowedSizes excludes a
+// given sector size is skipped by both StorageBestAlloc and
+// StorageFindSector, and that a path with no AllowedSizes set (the pre-
+// chunk0-5 default) still accepts anything.
+func TestAllowedSizesFiltering(t *testing.T) {
+	ctx := context.Background()
+
+	idx, err := NewIndex(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantSize := abi.SectorSize(2048)
+	otherSize := abi.SectorSize(1024)
+
+	if err := idx.StorageAttach(ctx, StorageInfo{
+		ID:           ID("restricted"),
+		Weight:       1,
+		CanSeal:      true,
+		CanStore:     true,
+		AllowedSizes: []abi.SectorSize{otherSize},
+	}, FsStat{Capacity: 1000000, Available: 1000000}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := idx.StorageAttach(ctx, StorageInfo{
+		ID:       ID("unrestricted"),
+		Weight:   1,
+		CanSeal:  true,
+		CanStore: true,
+	}, FsStat{Capacity: 1000000, Available: 1000000}); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates, err := idx.StorageBestAlloc(ctx, FTUnsealed, wantSize, PathSealing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range candidates {
+		if c.ID == ID("restricted") {
+			t.Fatalf("expected %q to be excluded for a size not in its AllowedSizes", c.ID)
+		}
+	}
+
+	found := false
+	for _, c := range candidates {
+		if c.ID == ID("unrestricted") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected the unrestricted path to still be a candidate")
+	}
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	if err := idx.StorageDeclareSector(ctx, ID("restricted"), sector, FTUnsealed, true); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := idx.StorageFindSector(ctx, sector, FTUnsealed, wantSize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 0 {
+		t.Fatalf("expected StorageFindSector to exclude a declared copy on a size-mismatched path, got %v", infos)
+	}
+}
@@ -0,0 +1,58 @@
+package stores
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// TestStorageDeclareSectorPrimaryDemotion checks that declaring a new
+// primary copy of a (sector, file type) demotes whichever copy previously
+// held primary, so a sector never ends up with two primary copies.
+func TestStorageDeclareSectorPrimaryDemotion(t *testing.T) {
+	ctx := context.Background()
+
+	idx, err := NewIndex(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+
+	if err := idx.StorageDeclareSector(ctx, ID("storageA"), sector, FTUnsealed, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.StorageDeclareSector(ctx, ID("storageB"), sector, FTUnsealed, true); err != nil {
+		t.Fatal(err)
+	}
+
+	decls := idx.sectors[Decl{sector, FTUnsealed}]
+	if len(decls) != 2 {
+		t.Fatalf("expected 2 declarations, got %d", len(decls))
+	}
+
+	var primaryCount int
+	var aPrimary, bPrimary bool
+	for _, d := range decls {
+		if d.Primary {
+			primaryCount++
+		}
+		switch d.ID {
+		case ID("storageA"):
+			aPrimary = d.Primary
+		case ID("storageB"):
+			bPrimary = d.Primary
+		}
+	}
+
+	if primaryCount != 1 {
+		t.Fatalf("expected exactly one primary copy, got %d", primaryCount)
+	}
+	if aPrimary {
+		t.Fatal("expected storageA to have been demoted from primary")
+	}
+	if !bPrimary {
+		t.Fatal("expected storageB to be the sole primary copy")
+	}
+}
@@ -0,0 +1,159 @@
+package stores
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+func attachDetachTestStorage(t *testing.T, ctx context.Context, idx *Index, id ID, weight uint64) {
+	t.Helper()
+
+	if err := idx.StorageAttach(ctx, StorageInfo{
+		ID:       id,
+		Weight:   weight,
+		CanSeal:  true,
+		CanStore: true,
+	}, FsStat{Capacity: 1000000, Available: 1000000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestStorageDetachIfReplicated checks that DetachIfReplicated refuses to
+// detach a path holding the only copy of a sector, and succeeds once that
+// sector has a second copy elsewhere.
+func TestStorageDetachIfReplicated(t *testing.T) {
+	ctx := context.Background()
+
+	idx, err := NewIndex(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachDetachTestStorage(t, ctx, idx, ID("a"), 1)
+	attachDetachTestStorage(t, ctx, idx, ID("b"), 1)
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	if err := idx.StorageDeclareSector(ctx, ID("a"), sector, FTUnsealed, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := idx.StorageDetach(ctx, ID("a"), 2048, DetachOpts{Mode: DetachIfReplicated}); err == nil {
+		t.Fatal("expected DetachIfReplicated to refuse detaching the only copy of a sector")
+	}
+
+	if err := idx.StorageDeclareSector(ctx, ID("b"), sector, FTUnsealed, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := idx.StorageDetach(ctx, ID("a"), 2048, DetachOpts{Mode: DetachIfReplicated}); err != nil {
+		t.Fatalf("expected DetachIfReplicated to succeed once the sector is replicated: %v", err)
+	}
+
+	if _, ok := idx.stores[ID("a")]; ok {
+		t.Fatal("expected storage a to have been removed from the index")
+	}
+}
+
+// TestStorageDetachMigrateLastPrimary checks that DetachMigrate refuses to
+// plan a migration for a sector whose only copy on the detaching path is
+// also its last primary copy.
+func TestStorageDetachMigrateLastPrimary(t *testing.T) {
+	ctx := context.Background()
+
+	idx, err := NewIndex(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachDetachTestStorage(t, ctx, idx, ID("a"), 1)
+	attachDetachTestStorage(t, ctx, idx, ID("b"), 1)
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	if err := idx.StorageDeclareSector(ctx, ID("a"), sector, FTUnsealed, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.StorageDeclareSector(ctx, ID("b"), sector, FTUnsealed, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := idx.StorageDetach(ctx, ID("a"), 2048, DetachOpts{Mode: DetachMigrate}); err == nil {
+		t.Fatal("expected DetachMigrate to refuse migrating the last primary copy")
+	}
+
+	if _, ok := idx.stores[ID("a")]; !ok {
+		t.Fatal("a refused DetachMigrate must not remove the storage from the index")
+	}
+}
+
+// TestStorageDetachMigratePlan checks that DetachMigrate returns a plan
+// naming every declared (sector, file type) on the detaching path, targeting
+// a still-attached store, without mutating the index itself.
+func TestStorageDetachMigratePlan(t *testing.T) {
+	ctx := context.Background()
+
+	idx, err := NewIndex(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachDetachTestStorage(t, ctx, idx, ID("a"), 1)
+	attachDetachTestStorage(t, ctx, idx, ID("b"), 1)
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	if err := idx.StorageDeclareSector(ctx, ID("a"), sector, FTCache, false); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := idx.StorageDetach(ctx, ID("a"), 2048, DetachOpts{Mode: DetachMigrate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan) != 1 {
+		t.Fatalf("expected a single planned move, got %d", len(plan))
+	}
+	if plan[0].Sector != sector || plan[0].FileType != FTCache {
+		t.Fatalf("unexpected planned move: %+v", plan[0])
+	}
+	if plan[0].Target != ID("b") {
+		t.Fatalf("expected the plan to target storage b, got %s", plan[0].Target)
+	}
+
+	if _, ok := idx.stores[ID("a")]; !ok {
+		t.Fatal("DetachMigrate must not remove the storage from the index until the caller completes the moves")
+	}
+	if len(idx.sectors[Decl{sector, FTCache}]) != 1 {
+		t.Fatal("DetachMigrate must not mutate sector declarations itself")
+	}
+}
+
+// TestStorageDetachForce checks that DetachForce drops every declaration
+// referencing the path and removes it from the index immediately.
+func TestStorageDetachForce(t *testing.T) {
+	ctx := context.Background()
+
+	idx, err := NewIndex(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachDetachTestStorage(t, ctx, idx, ID("a"), 1)
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	if err := idx.StorageDeclareSector(ctx, ID("a"), sector, FTUnsealed, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := idx.StorageDetach(ctx, ID("a"), 2048, DetachOpts{Mode: DetachForce}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := idx.stores[ID("a")]; ok {
+		t.Fatal("expected storage a to have been removed from the index")
+	}
+	if len(idx.sectors[Decl{sector, FTUnsealed}]) != 0 {
+		t.Fatal("expected DetachForce to drop the sector's declaration on the detached path")
+	}
+}
@@ -0,0 +1,133 @@
+package stores
+
+import (
+	"context"
+	"time"
+
+	"testing"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+var testSector = abi.SectorID{Miner: 1000, Number: 1}
+
+// TestStorageLockMutualExclusion checks that a write lock on a file type
+// blocks a concurrent reader until released, and that the reader unblocks as
+// soon as the writer calls its release func.
+func TestStorageLockMutualExclusion(t *testing.T) {
+	idx, err := NewIndex(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	releaseWrite, err := idx.StorageLock(context.Background(), testSector, 0, FTCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := idx.StorageLock(context.Background(), testSector, FTCache, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer release()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("reader acquired the lock while the writer still held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	releaseWrite()
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("reader never acquired the lock after the writer released it")
+	}
+}
+
+// TestStorageTryLockNonBlocking checks that StorageTryLock fails immediately
+// (returning a nil func and no error) rather than blocking when the lock
+// isn't available, and succeeds once it is.
+func TestStorageTryLockNonBlocking(t *testing.T) {
+	idx, err := NewIndex(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := idx.StorageLock(context.Background(), testSector, 0, FTUnsealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := idx.StorageTryLock(context.Background(), testSector, FTUnsealed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Fatal("expected StorageTryLock to fail while the writer holds FTUnsealed")
+	}
+
+	release()
+
+	got, err = idx.StorageTryLock(context.Background(), testSector, FTUnsealed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Fatal("expected StorageTryLock to succeed once the writer released")
+	}
+	got()
+}
+
+// TestStorageLockContextCancel checks that a blocked StorageLock call returns
+// ctx.Err() once ctx is canceled, instead of hanging until the holder
+// releases.
+func TestStorageLockContextCancel(t *testing.T) {
+	idx, err := NewIndex(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := idx.StorageLock(context.Background(), testSector, 0, FTUnsealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = idx.StorageLock(ctx, testSector, FTUnsealed, 0)
+	if err == nil {
+		t.Fatal("expected StorageLock to return an error once ctx was canceled")
+	}
+}
+
+// TestSectorLocksCleanup checks that getSectorLock/putSectorLock don't leak a
+// *sectorLock entry once every caller referencing it has released.
+func TestSectorLocksCleanup(t *testing.T) {
+	idx, err := NewIndex(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := idx.StorageLock(context.Background(), testSector, 0, FTUnsealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	idx.sectorLk.Lock()
+	_, ok := idx.sectorLocks[testSector]
+	idx.sectorLk.Unlock()
+
+	if ok {
+		t.Fatal("sectorLocks still holds an entry for a sector with no waiters or holders")
+	}
+}
@@ -0,0 +1,70 @@
+package stores
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// TestStorageReserveAccounting exercises the StorageReserve/StorageBestAlloc
+// interaction end to end: a reservation must shrink what StorageBestAlloc
+// considers available, releasing it must give that space back, and
+// releasing twice must not double-credit the path with space it doesn't
+// have.
+func TestStorageReserveAccounting(t *testing.T) {
+	ctx := context.Background()
+
+	idx, err := NewIndex(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := ID("alloc")
+	if err := idx.StorageAttach(ctx, StorageInfo{
+		ID:       id,
+		Weight:   1,
+		CanSeal:  true,
+		CanStore: true,
+	}, FsStat{Capacity: 1000000, Available: 1000000}); err != nil {
+		t.Fatal(err)
+	}
+
+	ssize := abi.SectorSize(2048)
+
+	if _, err := idx.StorageBestAlloc(ctx, FTUnsealed, ssize, PathSealing); err != nil {
+		t.Fatalf("expected an allocation candidate before any reservation: %v", err)
+	}
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	release, err := idx.StorageReserve(ctx, id, sector, FTUnsealed, 999990)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if idx.stores[id].reserved != 999990 {
+		t.Fatalf("expected reserved to be 999990, got %d", idx.stores[id].reserved)
+	}
+
+	if _, err := idx.StorageBestAlloc(ctx, FTUnsealed, ssize, PathSealing); err == nil {
+		t.Fatal("expected no allocation candidate once reservation left too little space")
+	}
+
+	release()
+
+	if idx.stores[id].reserved != 0 {
+		t.Fatalf("expected reserved to be back to 0 after release, got %d", idx.stores[id].reserved)
+	}
+
+	if _, err := idx.StorageBestAlloc(ctx, FTUnsealed, ssize, PathSealing); err != nil {
+		t.Fatalf("expected an allocation candidate again after releasing the reservation: %v", err)
+	}
+
+	// A second release of the same reservation must be a no-op, not a
+	// double-decrement that drives reserved negative.
+	release()
+
+	if idx.stores[id].reserved != 0 {
+		t.Fatalf("expected reserved to remain 0 after a duplicate release, got %d", idx.stores[id].reserved)
+	}
+}
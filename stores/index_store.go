@@ -0,0 +1,197 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// IndexStore persists the mutations applied to an Index so that a restart
+// doesn't forget every StorageAttach/StorageDeclareSector/StorageDropSector
+// call. Index replays the journal returned by Load on startup and calls
+// Append under i.lk for every mutating call in between snapshots.
+type IndexStore interface {
+	// Load returns the last snapshot (nil if none was ever written) together
+	// with the log entries appended since that snapshot, oldest first.
+	Load(ctx context.Context) (*indexSnapshot, []indexLogEntry, error)
+
+	// Append journals a single mutating operation.
+	Append(ctx context.Context, entry indexLogEntry) error
+
+	// Snapshot persists the full current state and discards the journal
+	// entries made obsolete by it.
+	Snapshot(ctx context.Context, snap *indexSnapshot) error
+
+	Close() error
+}
+
+type indexOpKind string
+
+const (
+	indexOpAttach      indexOpKind = "attach"
+	indexOpDeclare     indexOpKind = "declare"
+	indexOpDrop        indexOpKind = "drop"
+	indexOpDetachStore indexOpKind = "detach-store"
+)
+
+// indexLogEntry is one journaled mutation. Only the fields relevant to Kind
+// are populated.
+type indexLogEntry struct {
+	Kind indexOpKind
+
+	Storage *StorageInfo `json:",omitempty"`
+	Fsi     *FsStat      `json:",omitempty"`
+
+	StorageID ID             `json:",omitempty"`
+	Sector    abi.SectorID   `json:",omitempty"`
+	FileType  SectorFileType `json:",omitempty"`
+	Primary   bool           `json:",omitempty"`
+}
+
+// indexSnapshot is a flattened, JSON-friendly copy of Index's internal maps.
+type indexSnapshot struct {
+	Stores  []storeSnapshot
+	Sectors []sectorDeclSnapshot
+}
+
+type storeSnapshot struct {
+	Info StorageInfo
+	Fsi  FsStat
+}
+
+type sectorDeclSnapshot struct {
+	Sector   abi.SectorID
+	FileType SectorFileType
+	Entries  []declMeta
+}
+
+// jsonIndexStore is the default IndexStore: a snapshot file plus an
+// append-only JSON-lines journal. It's meant for single-node miners; bigger
+// deployments can plug in a badger/sqlite backed IndexStore instead.
+type jsonIndexStore struct {
+	lk sync.Mutex
+
+	snapshotPath string
+	logPath      string
+	logFile      *os.File
+}
+
+// NewJSONIndexStore creates an IndexStore that keeps its snapshot and
+// journal files in dir.
+func NewJSONIndexStore(dir string) (IndexStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, xerrors.Errorf("creating index store dir: %w", err)
+	}
+
+	s := &jsonIndexStore{
+		snapshotPath: filepath.Join(dir, "index.snapshot.json"),
+		logPath:      filepath.Join(dir, "index.log"),
+	}
+
+	f, err := os.OpenFile(s.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, xerrors.Errorf("opening index journal: %w", err)
+	}
+	s.logFile = f
+
+	return s, nil
+}
+
+func (s *jsonIndexStore) Load(ctx context.Context) (*indexSnapshot, []indexLogEntry, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	var snap *indexSnapshot
+
+	snapBytes, err := ioutil.ReadFile(s.snapshotPath)
+	switch {
+	case err == nil:
+		snap = &indexSnapshot{}
+		if err := json.Unmarshal(snapBytes, snap); err != nil {
+			return nil, nil, xerrors.Errorf("unmarshaling index snapshot: %w", err)
+		}
+	case os.IsNotExist(err):
+		// no snapshot yet, start from an empty state
+	default:
+		return nil, nil, xerrors.Errorf("reading index snapshot: %w", err)
+	}
+
+	logBytes, err := ioutil.ReadFile(s.logPath)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading index journal: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(logBytes))
+
+	var entries []indexLogEntry
+	for dec.More() {
+		var e indexLogEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, nil, xerrors.Errorf("decoding index journal entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	return snap, entries, nil
+}
+
+func (s *jsonIndexStore) Append(ctx context.Context, entry indexLogEntry) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return xerrors.Errorf("marshaling index journal entry: %w", err)
+	}
+	b = append(b, '\n')
+
+	if _, err := s.logFile.Write(b); err != nil {
+		return xerrors.Errorf("writing index journal entry: %w", err)
+	}
+
+	return s.logFile.Sync()
+}
+
+func (s *jsonIndexStore) Snapshot(ctx context.Context, snap *indexSnapshot) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return xerrors.Errorf("marshaling index snapshot: %w", err)
+	}
+
+	tmp := s.snapshotPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return xerrors.Errorf("writing index snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, s.snapshotPath); err != nil {
+		return xerrors.Errorf("renaming index snapshot: %w", err)
+	}
+
+	if err := s.logFile.Close(); err != nil {
+		return xerrors.Errorf("closing index journal: %w", err)
+	}
+	f, err := os.OpenFile(s.logPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return xerrors.Errorf("truncating index journal: %w", err)
+	}
+	s.logFile = f
+
+	return nil
+}
+
+func (s *jsonIndexStore) Close() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return s.logFile.Close()
+}
@@ -0,0 +1,158 @@
+package stores
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/specs-actors/actors/abi"
+)
+
+// TestIndexStoreRoundTrip checks that everything an Index journals through a
+// jsonIndexStore (attach, declare, drop) comes back after a fresh NewIndex
+// replays the journal. TestIndexStoreSnapshotCompaction covers replay from a
+// snapshot.
+func TestIndexStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index-store-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+
+	store, err := NewJSONIndexStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := NewIndex(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si := StorageInfo{
+		ID:       ID("storage0"),
+		URLs:     []string{"http://example.com/storage0"},
+		Weight:   10,
+		CanSeal:  true,
+		CanStore: true,
+	}
+	if err := idx.StorageAttach(ctx, si, FsStat{Capacity: 1000, Available: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	if err := idx.StorageDeclareSector(ctx, si.ID, sector, FTUnsealed|FTCache, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.StorageDropSector(ctx, si.ID, sector, FTCache); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewJSONIndexStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := NewIndex(ctx, reopened)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertSectorState(t, replayed, sector)
+}
+
+// TestIndexStoreSnapshotCompaction forces a snapshot compaction (by driving
+// the journal past snapshotInterval entries) and checks the replayed state
+// still matches after the journal has been truncated out from under it.
+func TestIndexStoreSnapshotCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index-store-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+
+	store, err := NewJSONIndexStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := NewIndex(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si := StorageInfo{ID: ID("storage0"), CanSeal: true, CanStore: true}
+	if err := idx.StorageAttach(ctx, si, FsStat{Capacity: 1000, Available: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	sector := abi.SectorID{Miner: 1000, Number: 1}
+	if err := idx.StorageDeclareSector(ctx, si.ID, sector, FTUnsealed, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pad the journal with enough no-op heartbeat attaches of a throwaway
+	// storage to push opsLog past snapshotInterval and trigger a compaction.
+	for n := 0; n < snapshotInterval+1; n++ {
+		if err := idx.StorageAttach(ctx, StorageInfo{ID: ID("padding")}, FsStat{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "index.snapshot.json")); err != nil {
+		t.Fatalf("expected a snapshot to have been written: %v", err)
+	}
+
+	reopened, err := NewJSONIndexStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := NewIndex(ctx, reopened)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertSectorState(t, replayed, sector)
+}
+
+func assertSectorState(t *testing.T, idx *Index, sector abi.SectorID) {
+	t.Helper()
+
+	info, err := idx.StorageFindSector(context.Background(), sector, FTUnsealed|FTCache, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foundUnsealed := false
+	for _, si := range info {
+		if si.ID == ID("storage0") {
+			foundUnsealed = true
+		}
+	}
+	if !foundUnsealed {
+		t.Fatal("expected FTUnsealed to still be declared on storage0 after replay")
+	}
+
+	cacheIDs, err := idx.FindSector(sector, FTCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cacheIDs) != 0 {
+		t.Fatalf("expected FTCache to have been dropped, got %v", cacheIDs)
+	}
+}